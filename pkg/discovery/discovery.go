@@ -0,0 +1,97 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package discovery is a generic, Prometheus-style service discovery
+// interface: a Provider watches some external system and pushes the full
+// current set of Targets whenever it changes; callers diff successive sets
+// themselves so only what actually changed needs to be acted on.
+package discovery
+
+// Target is a single collection target discovered by a Provider, eg one
+// container's log directory or one Consul service's advertised paths.
+type Target struct {
+	// Id uniquely identifies this target across discovery rounds, eg
+	// "<namespace>/<pod>/<container>", so rounds can be diffed against it.
+	Id string
+	// Paths are the glob patterns this target contributes.
+	Paths []string
+	// Fields are injected into every event collected from Paths, eg pod
+	// labels or Consul tags.
+	Fields map[string]interface{}
+}
+
+// Provider watches an external system (Kubernetes, Consul, ...) and pushes
+// the full current set of Targets on out whenever it changes, until stop is
+// closed.
+type Provider interface {
+	Name() string
+	Run(stop <-chan struct{}, out chan<- []Target) error
+}
+
+// Diff is the result of comparing two Target sets by Id.
+type Diff struct {
+	Added   []Target
+	Removed []Target
+	Changed []Target // same Id, different Paths/Fields
+}
+
+// DiffTargets compares prev and current, keyed by Target.Id, so a caller can
+// apply only the delta instead of tearing everything down on every round.
+func DiffTargets(prev, current []Target) Diff {
+	prevById := make(map[string]Target, len(prev))
+	for _, t := range prev {
+		prevById[t.Id] = t
+	}
+	currentById := make(map[string]Target, len(current))
+	for _, t := range current {
+		currentById[t.Id] = t
+	}
+
+	var d Diff
+	for id, t := range currentById {
+		old, ok := prevById[id]
+		if !ok {
+			d.Added = append(d.Added, t)
+			continue
+		}
+		if !targetEqual(old, t) {
+			d.Changed = append(d.Changed, t)
+		}
+	}
+	for id, t := range prevById {
+		if _, ok := currentById[id]; !ok {
+			d.Removed = append(d.Removed, t)
+		}
+	}
+	return d
+}
+
+func targetEqual(a, b Target) bool {
+	if len(a.Paths) != len(b.Paths) || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Paths {
+		if a.Paths[i] != b.Paths[i] {
+			return false
+		}
+	}
+	for k, v := range a.Fields {
+		if bv, ok := b.Fields[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
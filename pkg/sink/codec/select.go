@@ -17,63 +17,209 @@ limitations under the License.
 package codec
 
 import (
+	"fmt"
 	"github.com/pkg/errors"
 	"loggie.io/loggie/pkg/util"
-	"regexp"
 	"strings"
+	"time"
 )
 
-func InitMatcher(pattern string) [][]string {
-	// TODO regexp optimize
-	indexReg := regexp.MustCompile(`\${(.+?)}`)
-	return indexReg.FindAllStringSubmatch(pattern, -1)
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentField
+	segmentTime
+)
+
+// segment is one pre-parsed piece of a pattern, eg the literal "aa-", the
+// field reference "field.bb" (with an optional nested fallback pattern), or
+// the time token "+YYYY.MM.DD".
+type segment struct {
+	kind segmentKind
+
+	literal string // segmentLiteral
+
+	path     []string  // segmentField: pre-split lookup path
+	fallback []segment // segmentField: parsed default, nil if the field has none
+
+	format   string // segmentTime
+	location string // segmentTime: IANA timezone name, "" means local time
+}
+
+// InitMatcher pre-parses pattern once, typically at pipeline start, into a
+// sequence of typed segments so PatternSelect can render it per event without
+// re-running regex/strings.Replacer matching on every call.
+func InitMatcher(pattern string) []segment {
+	return parseSegments(pattern)
 }
 
 // PatternSelect
 // eg: pattern: aa-${field.bb}-${+YYYY.MM.DD}
 // field.bb in event is xx
 // would be format to: aa-xx-2021.07.04
-func PatternSelect(result *Result, pattern string, matcher [][]string) (string, error) {
+//
+// Fields also support a default value and type coercion, eg
+// ${field.bb:unknown} falls back to "unknown" when field.bb is missing, and
+// non-string values (numbers, bools, times, ...) are rendered via fmt.Sprint.
+// Defaults can themselves reference other fields, chaining fallbacks, eg
+// ${field.a:${field.b:unknown}}. Time tokens accept an optional timezone,
+// eg ${+YYYY-MM-DDTHH:mm:ssZ@Asia/Shanghai}.
+func PatternSelect(result *Result, pattern string, matcher []segment) (string, error) {
 	if len(matcher) == 0 {
 		return pattern, nil
 	}
-	var oldNew []string
 
-	for _, m := range matcher {
-		keyWrap := m[0] // ${fields.xx}
-		key := m[1]     // fields.xx
+	var sb strings.Builder
+	if err := writeSegments(&sb, result, matcher); err != nil {
+		return "", errors.WithMessage(err, "replace pattern error")
+	}
+	return sb.String(), nil
+}
 
-		alt, err := getNew(result, key)
-		if err != nil {
-			return "", errors.WithMessage(err, "replace pattern error")
+func writeSegments(sb *strings.Builder, result *Result, segments []segment) error {
+	for _, seg := range segments {
+		if err := writeSegment(sb, result, seg); err != nil {
+			return err
 		}
-		// add old
-		oldNew = append(oldNew, keyWrap)
-		// add new
-		oldNew = append(oldNew, alt)
 	}
+	return nil
+}
+
+func writeSegment(sb *strings.Builder, result *Result, seg segment) error {
+	switch seg.kind {
+	case segmentLiteral:
+		sb.WriteString(seg.literal)
+		return nil
 
-	replacer := strings.NewReplacer(oldNew...)
-	res := replacer.Replace(pattern)
+	case segmentTime:
+		if seg.location == "" {
+			sb.WriteString(util.TimeFormatNow(seg.format))
+			return nil
+		}
+		loc, err := time.LoadLocation(seg.location)
+		if err != nil {
+			return errors.WithMessagef(err, "load location %s error", seg.location)
+		}
+		sb.WriteString(util.TimeFormatNowInLocation(seg.format, loc))
+		return nil
 
-	return res, nil
+	case segmentField:
+		val, err := result.Lookup(seg.path...)
+		if err != nil {
+			if seg.fallback == nil {
+				return errors.WithMessagef(err, "look up %v error", seg.path)
+			}
+			return writeSegments(sb, result, seg.fallback)
+		}
+		sb.WriteString(toString(val))
+		return nil
+	}
+	return nil
+}
+
+// toString coerces a looked-up field value to text. Strings pass through
+// as-is; everything else (numbers, bools, times, ...) is rendered with
+// fmt.Sprint instead of failing the whole pattern.
+func toString(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return s
+	}
+	return fmt.Sprint(val)
 }
 
 const timeToken = "+"
 
-func getNew(result *Result, key string) (string, error) {
-	if strings.HasPrefix(key, timeToken) { // timeFormat
-		return util.TimeFormatNow(strings.TrimLeft(key, timeToken)), nil
+// parseSegments splits pattern into literal text and ${...} tokens, matching
+// braces by depth so a default's nested ${...} doesn't prematurely close the
+// enclosing token, eg in "${field.a:${field.b:unknown}}".
+func parseSegments(pattern string) []segment {
+	var segments []segment
+	i := 0
+	for i < len(pattern) {
+		start := strings.Index(pattern[i:], "${")
+		if start == -1 {
+			segments = append(segments, segment{kind: segmentLiteral, literal: pattern[i:]})
+			break
+		}
+		start += i
+		if start > i {
+			segments = append(segments, segment{kind: segmentLiteral, literal: pattern[i:start]})
+		}
+
+		end := matchingBrace(pattern, start+2)
+		if end == -1 {
+			segments = append(segments, segment{kind: segmentLiteral, literal: pattern[start:]})
+			break
+		}
+
+		segments = append(segments, parseToken(pattern[start+2:end]))
+		i = end + 1
 	}
+	return segments
+}
 
-	paths := util.GetQueryPaths(key)
-	val, err := result.Lookup(paths...)
-	if err != nil {
-		return "", errors.WithMessagef(err, "look up %v error", paths)
+// matchingBrace returns the index of the '}' that closes the '{' opened right
+// before from, counting nested '{'/'}' pairs in between.
+func matchingBrace(pattern string, from int) int {
+	depth := 1
+	for j := from; j < len(pattern); j++ {
+		switch pattern[j] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
 	}
-	valStr, ok := val.(string)
-	if !ok {
-		return "", errors.New("not a string")
+	return -1
+}
+
+func parseToken(content string) segment {
+	if strings.HasPrefix(content, timeToken) {
+		return parseTimeToken(strings.TrimPrefix(content, timeToken))
 	}
-	return valStr, nil
-}
\ No newline at end of file
+	return parseFieldToken(content)
+}
+
+func parseTimeToken(content string) segment {
+	format := content
+	location := ""
+	if idx := strings.LastIndex(content, "@"); idx != -1 {
+		format = content[:idx]
+		location = content[idx+1:]
+	}
+	return segment{kind: segmentTime, format: format, location: location}
+}
+
+func parseFieldToken(content string) segment {
+	key := content
+	var fallback []segment
+	if idx := topLevelColon(content); idx != -1 {
+		key = content[:idx]
+		fallback = parseSegments(content[idx+1:])
+	}
+	return segment{kind: segmentField, path: util.GetQueryPaths(key), fallback: fallback}
+}
+
+// topLevelColon finds the ':' splitting a field key from its default value,
+// ignoring any ':' nested inside a default's own ${...} token.
+func topLevelColon(content string) int {
+	depth := 0
+	for i := 0; i < len(content); i++ {
+		switch content[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
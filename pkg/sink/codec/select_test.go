@@ -0,0 +1,151 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import "testing"
+
+func TestParseSegmentsLiteral(t *testing.T) {
+	segments := parseSegments("aa-bb")
+	if len(segments) != 1 || segments[0].kind != segmentLiteral || segments[0].literal != "aa-bb" {
+		t.Fatalf("got %+v, want a single literal segment \"aa-bb\"", segments)
+	}
+}
+
+func TestParseSegmentsField(t *testing.T) {
+	segments := parseSegments("aa-${field.bb}-cc")
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3: %+v", len(segments), segments)
+	}
+	if segments[0].kind != segmentLiteral || segments[0].literal != "aa-" {
+		t.Errorf("segment 0 = %+v, want literal \"aa-\"", segments[0])
+	}
+	if segments[1].kind != segmentField || segments[1].fallback != nil {
+		t.Errorf("segment 1 = %+v, want field with no fallback", segments[1])
+	}
+	if segments[2].kind != segmentLiteral || segments[2].literal != "-cc" {
+		t.Errorf("segment 2 = %+v, want literal \"-cc\"", segments[2])
+	}
+}
+
+func TestParseSegmentsFieldWithDefault(t *testing.T) {
+	segments := parseSegments("${field.bb:unknown}")
+	if len(segments) != 1 || segments[0].kind != segmentField {
+		t.Fatalf("got %+v, want a single field segment", segments)
+	}
+	fallback := segments[0].fallback
+	if len(fallback) != 1 || fallback[0].kind != segmentLiteral || fallback[0].literal != "unknown" {
+		t.Errorf("fallback = %+v, want a single literal \"unknown\"", fallback)
+	}
+}
+
+// TestParseSegmentsNestedDefault covers the case the brace-matching exists
+// for: a default that itself contains a ${...} token, eg another field with
+// its own default. matchingBrace must not close the outer token on the
+// inner token's '}'.
+func TestParseSegmentsNestedDefault(t *testing.T) {
+	segments := parseSegments("${field.a:${field.b:unknown}}")
+	if len(segments) != 1 || segments[0].kind != segmentField {
+		t.Fatalf("got %+v, want a single field segment", segments)
+	}
+	if got := segments[0].path; len(got) == 0 {
+		t.Fatalf("path not parsed from outer token")
+	}
+
+	fallback := segments[0].fallback
+	if len(fallback) != 1 || fallback[0].kind != segmentField {
+		t.Fatalf("fallback = %+v, want a single nested field segment", fallback)
+	}
+	innerFallback := fallback[0].fallback
+	if len(innerFallback) != 1 || innerFallback[0].kind != segmentLiteral || innerFallback[0].literal != "unknown" {
+		t.Errorf("inner fallback = %+v, want a single literal \"unknown\"", innerFallback)
+	}
+}
+
+func TestParseSegmentsTimeToken(t *testing.T) {
+	segments := parseSegments("${+YYYY.MM.DD}")
+	if len(segments) != 1 || segments[0].kind != segmentTime {
+		t.Fatalf("got %+v, want a single time segment", segments)
+	}
+	if segments[0].format != "YYYY.MM.DD" || segments[0].location != "" {
+		t.Errorf("segment = %+v, want format YYYY.MM.DD with no location", segments[0])
+	}
+}
+
+func TestParseSegmentsTimeTokenWithLocation(t *testing.T) {
+	segments := parseSegments("${+YYYY-MM-DDTHH:mm:ssZ@Asia/Shanghai}")
+	if len(segments) != 1 || segments[0].kind != segmentTime {
+		t.Fatalf("got %+v, want a single time segment", segments)
+	}
+	if segments[0].format != "YYYY-MM-DDTHH:mm:ssZ" {
+		t.Errorf("format = %q, want %q", segments[0].format, "YYYY-MM-DDTHH:mm:ssZ")
+	}
+	if segments[0].location != "Asia/Shanghai" {
+		t.Errorf("location = %q, want %q", segments[0].location, "Asia/Shanghai")
+	}
+}
+
+func TestParseSegmentsUnterminatedToken(t *testing.T) {
+	segments := parseSegments("aa-${field.bb")
+	if len(segments) != 2 {
+		t.Fatalf("got %d segments, want 2: %+v", len(segments), segments)
+	}
+	if segments[1].kind != segmentLiteral || segments[1].literal != "${field.bb" {
+		t.Errorf("unterminated token = %+v, want it passed through literally", segments[1])
+	}
+}
+
+func TestMatchingBrace(t *testing.T) {
+	cases := []struct {
+		pattern string
+		from    int
+		want    int
+	}{
+		{"{a}", 1, 2},
+		{"{a{b}c}", 1, 6},
+		{"{a{b}c", 1, -1},
+	}
+	for _, c := range cases {
+		if got := matchingBrace(c.pattern, c.from); got != c.want {
+			t.Errorf("matchingBrace(%q, %d) = %d, want %d", c.pattern, c.from, got, c.want)
+		}
+	}
+}
+
+func TestTopLevelColon(t *testing.T) {
+	cases := []struct {
+		content string
+		want    int
+	}{
+		{"field.bb", -1},
+		{"field.bb:unknown", 8},
+		{"field.a:${field.b:unknown}", 7},
+	}
+	for _, c := range cases {
+		if got := topLevelColon(c.content); got != c.want {
+			t.Errorf("topLevelColon(%q) = %d, want %d", c.content, got, c.want)
+		}
+	}
+}
+
+func TestToString(t *testing.T) {
+	if got := toString("xx"); got != "xx" {
+		t.Errorf("toString(string) = %q, want %q", got, "xx")
+	}
+	if got := toString(42); got != "42" {
+		t.Errorf("toString(int) = %q, want %q", got, "42")
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"loggie.io/loggie/pkg/core/log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var defaultCompressionSuffixes = []string{".gz", ".bz2", ".zst", ".xz"}
+
+// CompressionConfig lets the file source transparently tail compressed and
+// rotated-out archives (eg app.log.1.gz) left behind by external log
+// rotators, alongside the live plain-text file.
+type CompressionConfig struct {
+	// Suffixes lists the filename extensions treated as compressed. Defaults
+	// to .gz, .bz2, .zst, .xz.
+	Suffixes []string `yaml:"suffixes,omitempty"`
+	// DeleteAfterRead removes an archive once it has been fully consumed.
+	DeleteAfterRead bool `yaml:"deleteAfterRead,omitempty"`
+}
+
+func isCompressedSuffix(filename string, suffixes []string) bool {
+	list := suffixes
+	if len(list) == 0 {
+		list = defaultCompressionSuffixes
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, s := range list {
+		if strings.ToLower(s) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// openCompressed wraps file in the decompressor matching its suffix and, if
+// lines were already committed in a previous run, replays from the start of
+// the decompressed stream and discards them so collection resumes exactly
+// where it left off. Compressed streams can't seek, so this is the only way
+// to resume: NextOffset/Seek don't apply once j.compressed is true.
+func (j *Job) openCompressed(file *os.File) error {
+	decompressor, closer, err := newDecompressReader(j.filename, file)
+	if err != nil {
+		return err
+	}
+
+	j.compressed = true
+	j.reader = bufio.NewReader(decompressor)
+	j.closer = closer
+
+	if j.currentLineNumber > 0 {
+		if err := skipLines(j.reader, j.currentLineNumber); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDecompressReader returns the io.Reader that decodes file according to
+// its suffix, and the io.Closer that should be released alongside it (some
+// decompressors, eg zstd, hold buffers that must be explicitly freed).
+func newDecompressReader(filename string, file io.Reader) (io.Reader, io.Closer, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz":
+		r, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gzip.NewReader(filename: %s) error: %w", filename, err)
+		}
+		return r, r, nil
+
+	case ".bz2":
+		return bzip2.NewReader(file), noopCloser{}, nil
+
+	case ".zst":
+		r, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zstd.NewReader(filename: %s) error: %w", filename, err)
+		}
+		return r, zstdCloser{r}, nil
+
+	case ".xz":
+		r, err := xz.NewReader(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("xz.NewReader(filename: %s) error: %w", filename, err)
+		}
+		return r, noopCloser{}, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression suffix for filename: %s", filename)
+	}
+}
+
+// noopCloser satisfies io.Closer for decompressors (eg bzip2, xz) whose
+// stdlib/vendor readers don't hold anything that needs releasing.
+type noopCloser struct{}
+
+func (noopCloser) Close() error { return nil }
+
+// zstdCloser adapts *zstd.Decoder's Close() (no error return) to io.Closer.
+type zstdCloser struct {
+	dec *zstd.Decoder
+}
+
+func (z zstdCloser) Close() error {
+	z.dec.Close()
+	return nil
+}
+
+// skipLines discards the first n lines of r, used to fast-forward a
+// freshly re-decompressed stream to the point a previous run left off.
+func skipLines(r *bufio.Reader, n int64) error {
+	for i := int64(0); i < n; i++ {
+		_, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				log.Info("skipLines reached EOF after %d/%d lines", i, n)
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
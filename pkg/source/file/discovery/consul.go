@@ -0,0 +1,120 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"loggie.io/loggie/pkg/core/log"
+	coredisc "loggie.io/loggie/pkg/discovery"
+)
+
+const defaultConsulPollInterval = 10 * time.Second
+
+// ConsulConfig configures the Consul KV discovery provider.
+type ConsulConfig struct {
+	// Address is the Consul HTTP API address, eg "127.0.0.1:8500". Empty
+	// uses the client's default (CONSUL_HTTP_ADDR or 127.0.0.1:8500).
+	Address string `yaml:"address,omitempty"`
+	// KVPrefix is watched for keys "<prefix>/<service>/paths" (a
+	// newline-separated glob list) and "<prefix>/<service>/<tag>" (arbitrary
+	// metadata injected as a staticField).
+	KVPrefix string `yaml:"kvPrefix,omitempty"`
+	// PollInterval bounds how long a blocking KV query waits between rounds,
+	// and how long Run backs off after a List error. Defaults to 10s.
+	PollInterval time.Duration `yaml:"pollInterval,omitempty"`
+}
+
+// ConsulProvider watches a Consul KV prefix for path patterns and tags,
+// synthesizing one coredisc.Target per service found under the prefix.
+type ConsulProvider struct {
+	cfg    *ConsulConfig
+	client *api.Client
+}
+
+func NewConsulProvider(cfg *ConsulConfig, client *api.Client) *ConsulProvider {
+	return &ConsulProvider{cfg: cfg, client: client}
+}
+
+func (p *ConsulProvider) Name() string {
+	return "consul"
+}
+
+func (p *ConsulProvider) Run(stop <-chan struct{}, out chan<- []coredisc.Target) error {
+	interval := p.cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultConsulPollInterval
+	}
+
+	var lastIndex uint64
+	for {
+		pairs, meta, err := p.client.KV().List(p.cfg.KVPrefix, &api.QueryOptions{WaitIndex: lastIndex, WaitTime: interval})
+		if err != nil {
+			log.Error("consul KV().List(prefix: %s) error: %s, retrying in %s", p.cfg.KVPrefix, err, interval)
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(interval):
+			}
+			continue
+		}
+
+		lastIndex = meta.LastIndex
+		out <- kvToTargets(p.cfg.KVPrefix, pairs)
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
+
+// kvToTargets groups the flat KV listing "<prefix>/<service>/<field>" into
+// one coredisc.Target per service, with the "paths" field split into a
+// glob list and every other field passed through as staticField metadata.
+func kvToTargets(prefix string, pairs api.KVPairs) []coredisc.Target {
+	byService := make(map[string]*coredisc.Target)
+	for _, kv := range pairs {
+		rel := strings.TrimPrefix(strings.TrimPrefix(kv.Key, prefix), "/")
+		parts := strings.SplitN(rel, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		service, field := parts[0], parts[1]
+
+		t, ok := byService[service]
+		if !ok {
+			t = &coredisc.Target{Id: service, Fields: map[string]interface{}{}}
+			byService[service] = t
+		}
+
+		if field == "paths" {
+			t.Paths = strings.Split(string(kv.Value), "\n")
+			continue
+		}
+		t.Fields[field] = string(kv.Value)
+	}
+
+	targets := make([]coredisc.Target, 0, len(byService))
+	for _, t := range byService {
+		targets = append(targets, *t)
+	}
+	return targets
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"fmt"
+
+	coredisc "loggie.io/loggie/pkg/discovery"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const podLogPathFormat = "/var/log/pods/%s_%s_%s/%s/*.log"
+
+// KubernetesConfig configures the Kubernetes pod discovery provider.
+type KubernetesConfig struct {
+	// Namespace restricts discovery to a single namespace; empty watches all.
+	Namespace string `yaml:"namespace,omitempty"`
+	// LabelSelector further restricts which pods are watched.
+	LabelSelector string `yaml:"labelSelector,omitempty"`
+}
+
+// KubernetesProvider watches Pods and synthesizes one coredisc.Target per
+// container, with paths under /var/log/pods/<ns>_<pod>_<uid>/<container>/*.log
+// and the pod's labels injected as Fields.
+type KubernetesProvider struct {
+	cfg    *KubernetesConfig
+	client kubernetes.Interface
+}
+
+func NewKubernetesProvider(cfg *KubernetesConfig, client kubernetes.Interface) *KubernetesProvider {
+	return &KubernetesProvider{cfg: cfg, client: client}
+}
+
+func (p *KubernetesProvider) Name() string {
+	return "kubernetes"
+}
+
+func (p *KubernetesProvider) Run(stop <-chan struct{}, out chan<- []coredisc.Target) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(p.client, 0,
+		informers.WithNamespace(p.cfg.Namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = p.cfg.LabelSelector
+		}),
+	)
+	podInformer := factory.Core().V1().Pods().Informer()
+
+	emit := func() {
+		out <- podsToTargets(podInformer.GetStore().List())
+	}
+	if _, err := podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { emit() },
+		UpdateFunc: func(interface{}, interface{}) { emit() },
+		DeleteFunc: func(interface{}) { emit() },
+	}); err != nil {
+		return fmt.Errorf("add pod event handler error: %w", err)
+	}
+
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+	emit()
+
+	<-stop
+	return nil
+}
+
+func podsToTargets(objs []interface{}) []coredisc.Target {
+	var targets []coredisc.Target
+	for _, obj := range objs {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			// each container gets its own Fields map: they're mutable, and
+			// must not alias across containers/targets sharing this pod.
+			fields := make(map[string]interface{}, len(pod.Labels))
+			for k, v := range pod.Labels {
+				fields[k] = v
+			}
+			targets = append(targets, coredisc.Target{
+				Id:     fmt.Sprintf("%s/%s/%s", pod.Namespace, pod.Name, c.Name),
+				Paths:  []string{fmt.Sprintf(podLogPathFormat, pod.Namespace, pod.Name, pod.UID, c.Name)},
+				Fields: fields,
+			})
+		}
+	}
+	return targets
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package discovery lets the file source synthesize and retract collection
+// jobs at runtime from external providers (Kubernetes, Consul, ...), instead
+// of only from static pipeline config.
+package discovery
+
+import (
+	"sync"
+
+	"loggie.io/loggie/pkg/core/log"
+	coredisc "loggie.io/loggie/pkg/discovery"
+)
+
+// WatchTaskPaths is the subset of *file.WatchTask the Manager needs. A
+// WatchTask implementing it should add/remove collection paths without
+// restarting jobs for paths that are unaffected, so their offsets survive
+// a reload, and apply staticFields atomically so no event is ever produced
+// against a stale metadata snapshot.
+type WatchTaskPaths interface {
+	AddPaths(paths []string, staticFields map[string]interface{}) error
+	RemovePaths(paths []string) error
+}
+
+// Manager watches one or more coredisc.Providers and reconciles their
+// target sets into a WatchTaskPaths, diffing successive rounds so only
+// added/removed/changed targets cause a change.
+type Manager struct {
+	task      WatchTaskPaths
+	providers []coredisc.Provider
+
+	mu      sync.Mutex
+	current map[string]coredisc.Target // by Target.Id
+
+	stop chan struct{}
+}
+
+func NewManager(task WatchTaskPaths, providers ...coredisc.Provider) *Manager {
+	return &Manager{
+		task:      task,
+		providers: providers,
+		current:   make(map[string]coredisc.Target),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Run starts every provider and reconciles their target sets until Stop is
+// called. It blocks, so callers typically run it in its own goroutine.
+func (m *Manager) Run() {
+	out := make(chan []coredisc.Target)
+	for _, p := range m.providers {
+		p := p
+		go func() {
+			if err := p.Run(m.stop, out); err != nil {
+				log.Error("discovery provider(%s) stopped: %s", p.Name(), err)
+			}
+		}()
+	}
+
+	for {
+		select {
+		case targets := <-out:
+			m.reconcile(targets)
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *Manager) Stop() {
+	close(m.stop)
+}
+
+// reconcile diffs targets against the last known set and applies only the
+// delta, so jobs for paths that didn't change are left alone.
+func (m *Manager) reconcile(targets []coredisc.Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := make([]coredisc.Target, 0, len(m.current))
+	for _, t := range m.current {
+		prev = append(prev, t)
+	}
+	diff := coredisc.DiffTargets(prev, targets)
+
+	for _, t := range diff.Removed {
+		if err := m.task.RemovePaths(t.Paths); err != nil {
+			log.Error("discovery RemovePaths(target: %s) error: %s", t.Id, err)
+			continue
+		}
+		delete(m.current, t.Id)
+	}
+
+	for _, t := range diff.Changed {
+		// a Changed target keeps its Id but may have dropped some of its old
+		// Paths (eg a pod losing a container): those need retiring too, or
+		// their jobs are never stopped even though the target itself lives on.
+		if stale := stalePaths(m.current[t.Id].Paths, t.Paths); len(stale) > 0 {
+			if err := m.task.RemovePaths(stale); err != nil {
+				log.Error("discovery RemovePaths(target: %s) error: %s", t.Id, err)
+			}
+		}
+	}
+
+	for _, t := range append(diff.Added, diff.Changed...) {
+		if err := m.task.AddPaths(t.Paths, t.Fields); err != nil {
+			log.Error("discovery AddPaths(target: %s) error: %s", t.Id, err)
+			continue
+		}
+		m.current[t.Id] = t
+	}
+}
+
+// stalePaths returns the entries of oldPaths no longer present in newPaths.
+func stalePaths(oldPaths, newPaths []string) []string {
+	keep := make(map[string]struct{}, len(newPaths))
+	for _, p := range newPaths {
+		keep[p] = struct{}{}
+	}
+	var stale []string
+	for _, p := range oldPaths {
+		if _, ok := keep[p]; !ok {
+			stale = append(stale, p)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// fileIdentity builds a stable key from the file's inode and device number.
+func fileIdentity(filename string, fileInfo os.FileInfo) (string, error) {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("filename: %s Sys() is not *syscall.Stat_t", filename)
+	}
+	inode := stat.Ino
+	device := uint64(stat.Dev)
+	var buf [64]byte
+	current := strconv.AppendUint(buf[:0], inode, 10)
+	current = append(current, '-')
+	current = strconv.AppendUint(current, device, 10)
+	return string(current), nil
+}
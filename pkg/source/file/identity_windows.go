@@ -0,0 +1,56 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileIdentity builds a stable key from the volume serial number and file
+// index, the Windows analogue of device+inode, via GetFileInformationByHandle.
+func fileIdentity(filename string, fileInfo os.FileInfo) (string, error) {
+	path, err := windows.UTF16PtrFromString(filename)
+	if err != nil {
+		return "", fmt.Errorf("filename: %s UTF16PtrFromString error: %w", filename, err)
+	}
+	handle, err := windows.CreateFile(path, windows.GENERIC_READ, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return "", fmt.Errorf("open filename: %s error: %w", filename, err)
+	}
+	defer windows.CloseHandle(handle)
+
+	var info windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(handle, &info); err != nil {
+		return "", fmt.Errorf("GetFileInformationByHandle(filename: %s) error: %w", filename, err)
+	}
+
+	var buf [96]byte
+	current := strconv.AppendUint(buf[:0], uint64(info.VolumeSerialNumber), 10)
+	current = append(current, '-')
+	current = strconv.AppendUint(current, uint64(info.FileIndexHigh), 10)
+	current = append(current, '-')
+	current = strconv.AppendUint(current, uint64(info.FileIndexLow), 10)
+	return string(current), nil
+}
@@ -17,16 +17,17 @@ limitations under the License.
 package file
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/md5"
 	"fmt"
 	"io"
 	"loggie.io/loggie/pkg/core/log"
 	"loggie.io/loggie/pkg/util"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
 )
 
@@ -37,6 +38,11 @@ const (
 	JobStopImmediately = JobStatus(999)
 
 	defaultIdentifier = "BLANK"
+
+	// defaultIdentityReadBytes is the fallback fingerprint size used by JobUid
+	// when the platform-level file identity (inode/device, Windows file index, ...)
+	// can't be obtained, eg on a network mount.
+	defaultIdentityReadBytes = 1024
 )
 
 var globalJobIndex uint32
@@ -63,18 +69,42 @@ type Job struct {
 	renameTime        time.Time
 	identifier        string
 
+	multiline   *multilineBuffer
+	multilineMu sync.Mutex // guards multiline: mutated by both the reader loop and the idle-flush ticker
+
+	compressed bool
+	reader     *bufio.Reader
+	closer     io.Closer
+	fullyRead  bool
+
+	// lineReader is the persistent buffered reader the read loop (read.go)
+	// reads lines from. It wraps Reader() once, on first read, and is kept
+	// across calls so a trailing unterminated line isn't dropped when a
+	// write lands between two reads; pendingLine carries that partial line
+	// forward until its newline arrives.
+	lineReader  *bufio.Reader
+	pendingLine []byte
+
+	// path is the AddPaths pattern that matched this job's filename, used to
+	// look up the staticFields (pod labels, Consul tags, ...) merged into
+	// every event collected from it.
+	path string
+
 	task *WatchTask
 }
 
-func JobUid(fileInfo os.FileInfo) string {
-	stat := fileInfo.Sys().(*syscall.Stat_t)
-	inode := stat.Ino
-	device := uint64(stat.Dev)
-	var buf [64]byte
-	current := strconv.AppendUint(buf[:0], inode, 10)
-	current = append(current, '-')
-	current = strconv.AppendUint(current, device, 10)
-	return string(current)
+// JobUid derives a stable identity for filename/fileInfo using the
+// platform-specific fileIdentity implementation (see identity_linux.go,
+// identity_darwin.go, identity_windows.go). When the OS-level identity API
+// is unavailable, eg on some network mounts, it falls back to a content
+// fingerprint of the first defaultIdentityReadBytes bytes of the file.
+func JobUid(filename string, fileInfo os.FileInfo) (string, error) {
+	uid, err := fileIdentity(filename, fileInfo)
+	if err == nil {
+		return uid, nil
+	}
+	log.Info("fileIdentity(filename: %s) error: %s, fallback to content fingerprint", filename, err)
+	return generateIdentifierFromFile(filename, defaultIdentityReadBytes)
 }
 
 func WatchJobId(pipelineName string, sourceName string, jobUid string) string {
@@ -124,6 +154,19 @@ func (j *Job) ChangeStatusTo(status JobStatus) {
 }
 
 func (j *Job) Release() {
+	if j.closer != nil {
+		if err := j.closer.Close(); err != nil {
+			log.Error("release job(fileName: %s) decompressor error: %s", j.filename, err)
+		}
+		j.closer = nil
+		j.reader = nil
+	}
+	// the underlying stream is going away: any buffered lineReader state
+	// (and the partial line it was carrying) no longer corresponds to
+	// anything readable and must be rebuilt on the next Active().
+	j.lineReader = nil
+	j.pendingLine = nil
+
 	if j.file == nil {
 		return
 	}
@@ -152,7 +195,11 @@ func (j *Job) IsRename() bool {
 
 func (j *Job) Active() error {
 	if j.file == nil {
-		// reopen
+		// reopen; any previously buffered lineReader/pendingLine belonged to
+		// the old file and must not be reused against the new one.
+		j.lineReader = nil
+		j.pendingLine = nil
+
 		file, err := os.Open(j.filename)
 		if err != nil {
 			if os.IsPermission(err) {
@@ -166,13 +213,22 @@ func (j *Job) Active() error {
 		if err != nil {
 			return err
 		}
-		newUid := JobUid(fileInfo)
+		newUid, err := JobUid(j.filename, fileInfo)
+		if err != nil {
+			return err
+		}
 		if j.Uid() != newUid {
 			return fmt.Errorf("job(filename: %s) uid(%s) changed to %s，it maybe not a file", j.filename, j.Uid(), newUid)
 		}
 
-		// reset file offset and lineNumber
-		if j.nextOffset != 0 {
+		if cfg := j.task.config.Compression; cfg != nil && isCompressedSuffix(j.filename, cfg.Suffixes) {
+			// compressed streams can't seek: re-decompress from the start and
+			// skip the lines already committed, tracked by currentLineNumber.
+			if err := j.openCompressed(file); err != nil {
+				return err
+			}
+		} else if j.nextOffset != 0 {
+			// reset file offset and lineNumber
 			_, err = file.Seek(j.nextOffset, io.SeekStart)
 			if err != nil {
 				return err
@@ -194,40 +250,93 @@ func (j *Job) Active() error {
 	return nil
 }
 
+// Reader returns the stream the job should be read from: the decompressed
+// stream for a compressed archive, or the raw file otherwise.
+func (j *Job) Reader() io.Reader {
+	if j.compressed {
+		return j.reader
+	}
+	return j.file
+}
+
+// IsCompressed reports whether this job reads a compressed, non-seekable
+// archive rather than tailing a plain-text file directly.
+func (j *Job) IsCompressed() bool {
+	return j.compressed
+}
+
+// MarkFullyRead is called by the reader once it reaches EOF on a compressed
+// archive. If deleteAfterRead is configured, the archive is safely removed
+// since it has been fully consumed and, being rotated-out, will never grow.
+func (j *Job) MarkFullyRead() {
+	if !j.compressed {
+		return
+	}
+	j.fullyRead = true
+
+	cfg := j.task.config.Compression
+	if cfg == nil || !cfg.DeleteAfterRead {
+		return
+	}
+	filename := j.filename
+	j.Release()
+	if err := os.Remove(filename); err != nil {
+		log.Error("deleteAfterRead remove filename: %s error: %s", filename, err)
+		return
+	}
+	log.Info("deleteAfterRead removed filename: %s", filename)
+}
+
 func (j *Job) NextOffset(offset int64) {
 	if offset > 0 {
 		j.nextOffset = offset
 	}
 }
 
+// GenerateIdentifier fingerprints the file's first bytes. For a compressed
+// archive this reads the compressed bytes directly (not the decoded
+// stream), so identity stays cheap and doesn't require decompressing.
 func (j *Job) GenerateIdentifier() error {
 	if j.identifier != "" {
 		return nil
 	}
-	stat, err := os.Stat(j.filename)
+	identifier, err := generateIdentifierFromFile(j.filename, j.task.config.FirstNBytesForIdentifier)
 	if err != nil {
 		return err
 	}
-	readSize := j.task.config.FirstNBytesForIdentifier
-	fileSize := stat.Size()
-	if fileSize < int64(readSize) {
-		return fmt.Errorf("file size is smaller than firstNBytesForIdentifier: %d < %d", fileSize, readSize)
-	}
-	file, err := os.Open(j.filename)
-	defer file.Close()
+	j.identifier = identifier
+	return nil
+}
+
+// generateIdentifierFromFile fingerprints the first readSize bytes of filename
+// via md5. It backs both Job.GenerateIdentifier and JobUid's fallback path.
+//
+// A file smaller than readSize (eg a freshly rotated or just-created log
+// file) is fingerprinted using whatever bytes it has instead of failing
+// outright: JobUid's fallback only runs once the platform file identity is
+// already unavailable, and erroring out here too would leave every small
+// file on that filesystem permanently uncollectable.
+func generateIdentifierFromFile(filename string, readSize int) (string, error) {
+	stat, err := os.Stat(filename)
 	if err != nil {
-		return err
+		return "", err
 	}
-	readBuffer := make([]byte, readSize)
-	l, err := file.Read(readBuffer)
+	if fileSize := stat.Size(); fileSize < int64(readSize) {
+		readSize = int(fileSize)
+	}
+
+	file, err := os.Open(filename)
 	if err != nil {
-		return err
+		return "", err
 	}
-	if l < readSize {
-		return fmt.Errorf("read size is smaller than firstNBytesForIdentifier: %d < %d", l, readSize)
+	defer file.Close()
+
+	readBuffer := make([]byte, readSize)
+	l, err := io.ReadFull(file, readBuffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
 	}
-	j.identifier = fmt.Sprintf("%x", md5.Sum(readBuffer))
-	return nil
+	return fmt.Sprintf("%x", md5.Sum(readBuffer[:l])), nil
 }
 
 func (j *Job) IsSame(other *Job) bool {
@@ -247,34 +356,51 @@ func (j *Job) Read() {
 	j.task.activeChan <- j
 }
 
+// ProductEvent is called by the reader for every `\n`-terminated line it
+// reads. With no multiline config it emits one event per line, same as
+// before. With multiline configured, lines are buffered (see multiline.go)
+// and productFunc is only called once a multi-line event's boundary is
+// reached, a limit is exceeded, or flushTimeout elapses.
 func (j *Job) ProductEvent(endOffset int64, collectTime time.Time, body []byte) {
 	nextOffset := endOffset + 1
-	contentBytes := int64(len(body))
-	// -1 because `\n`
-	startOffset := nextOffset - contentBytes - 1
+	contentBytes := int64(len(body)) + 1 // +1 because `\n`
+	startOffset := nextOffset - contentBytes
 
 	j.currentLineNumber++
 	j.currentLines++
 	j.endOffset = endOffset
 	j.nextOffset = nextOffset
+
+	if j.multiline == nil {
+		j.emitEvent(startOffset, nextOffset, j.currentLineNumber, collectTime, contentBytes, [][]byte{body})
+		return
+	}
+
+	j.productMultilineEvent(startOffset, nextOffset, j.currentLineNumber, collectTime, contentBytes, body)
+}
+
+// emitEvent builds the State and event for a (possibly multi-line) span of
+// the file and hands it to the pipeline. lines are joined with `\n` to
+// reconstruct the original body.
+func (j *Job) emitEvent(startOffset, nextOffset, lineNumber int64, collectTime time.Time, contentBytes int64, lines [][]byte) {
 	watchUid := j.WatchUid()
 
-	endOffsetStr := strconv.FormatInt(endOffset, 10)
 	var eventUid strings.Builder
-	eventUid.Grow(j.watchUidLen + 1 + len(endOffsetStr))
+	eventUid.Grow(j.watchUidLen + 2 + 2*20)
 	eventUid.WriteString(watchUid)
 	eventUid.WriteString("-")
-	eventUid.WriteString(endOffsetStr)
+	eventUid.WriteString(fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprintf("%d-%d", startOffset, nextOffset)))))
+
 	state := &State{
 		Epoch:        j.task.epoch,
 		PipelineName: j.task.pipelineName,
 		SourceName:   j.task.sourceName,
 		Offset:       startOffset,
 		NextOffset:   nextOffset,
-		LineNumber:   j.currentLineNumber,
+		LineNumber:   lineNumber,
 		Filename:     j.filename,
 		CollectTime:  collectTime,
-		ContentBytes: contentBytes + 1, // because `\n`
+		ContentBytes: contentBytes,
 		JobUid:       j.Uid(),
 		JobIndex:     j.Index(),
 		watchUid:     watchUid,
@@ -283,17 +409,32 @@ func (j *Job) ProductEvent(endOffset int64, collectTime time.Time, body []byte)
 	header := map[string]interface{}{
 		SystemStateKey: state,
 	}
+	if j.path != "" {
+		j.task.mu.RLock()
+		fields := j.task.staticFields[j.path]
+		j.task.mu.RUnlock()
+		for k, v := range fields {
+			header[k] = v
+		}
+	}
 	e := j.task.eventPool.Get()
 	// copy body,because readBuffer reuse
-	contentBuffer := make([]byte, contentBytes)
-	copy(contentBuffer, body)
-	e.Fill(header, contentBuffer)
+	body := bytes.Join(lines, []byte("\n"))
+	e.Fill(header, body)
 	j.task.productFunc(e)
 }
 
-func NewJob(task *WatchTask, filename string, fileInfo os.FileInfo) *Job {
-	jobUid := JobUid(fileInfo)
-	return newJobWithUid(task, filename, jobUid)
+// NewJob fails rather than create a Job with a blank uid: an empty Uid()
+// would make unrelated files collide in WatchUid()/IsSame() dedup, silently
+// merging or dropping jobs. Callers should skip filename and retry later
+// (eg once a network mount recovers) instead of collecting it without an
+// identity.
+func NewJob(task *WatchTask, filename string, fileInfo os.FileInfo) (*Job, error) {
+	jobUid, err := JobUid(filename, fileInfo)
+	if err != nil {
+		return nil, fmt.Errorf("JobUid(filename: %s) error: %w", filename, err)
+	}
+	return newJobWithUid(task, filename, jobUid), nil
 }
 
 func newJobWithUid(task *WatchTask, filename string, jobUid string) *Job {
@@ -304,6 +445,16 @@ func newJobWithUid(task *WatchTask, filename string, jobUid string) *Job {
 		uid:      jobUid,
 	}
 	j.aFileName.Store(filename)
+
+	if mlConfig := task.config.MultiLine; mlConfig != nil {
+		ml, err := newMultilineBuffer(mlConfig)
+		if err != nil {
+			log.Error("newMultilineBuffer(filename: %s) error: %s, multiline collection disabled for this job", filename, err)
+		} else {
+			j.multiline = ml
+		}
+	}
+
 	return j
 }
 
@@ -0,0 +1,204 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	MultilineMatchAfter  = "after"
+	MultilineMatchBefore = "before"
+
+	defaultMultilineMaxLines     = 500
+	defaultMultilineMaxBytes     = 10 * 1024 * 1024
+	defaultMultilineFlushTimeout = 5 * time.Second
+)
+
+// MultilineConfig configures multi-line event assembly for a source, eg to
+// keep a Java stack trace or a pretty-printed JSON document together as a
+// single event instead of one event per line.
+type MultilineConfig struct {
+	Pattern      string        `yaml:"pattern,omitempty" validate:"required"`
+	Match        string        `yaml:"match,omitempty" validate:"oneof=after before"`
+	Negate       bool          `yaml:"negate,omitempty"`
+	MaxLines     int           `yaml:"maxLines,omitempty"`
+	MaxBytes     int64         `yaml:"maxBytes,omitempty"`
+	FlushTimeout time.Duration `yaml:"flushTimeout,omitempty"`
+}
+
+// multilineBuffer accumulates the lines of a single multi-line event until
+// a boundary line arrives, a limit is exceeded, or flushTimeout elapses.
+type multilineBuffer struct {
+	cfg *MultilineConfig
+	re  *regexp.Regexp
+
+	lines      [][]byte
+	totalBytes int64
+
+	firstOffset    int64
+	firstLineNo    int64
+	lastNextOffset int64
+
+	updated time.Time
+}
+
+func newMultilineBuffer(cfg *MultilineConfig) (*multilineBuffer, error) {
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("compile multiline.pattern(%s) error: %w", cfg.Pattern, err)
+	}
+
+	match := cfg.Match
+	if match == "" {
+		match = MultilineMatchAfter
+	}
+	maxLines := cfg.MaxLines
+	if maxLines <= 0 {
+		maxLines = defaultMultilineMaxLines
+	}
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMultilineMaxBytes
+	}
+	flushTimeout := cfg.FlushTimeout
+	if flushTimeout <= 0 {
+		flushTimeout = defaultMultilineFlushTimeout
+	}
+
+	normalized := &MultilineConfig{
+		Pattern:      cfg.Pattern,
+		Match:        match,
+		Negate:       cfg.Negate,
+		MaxLines:     maxLines,
+		MaxBytes:     maxBytes,
+		FlushTimeout: flushTimeout,
+	}
+	return &multilineBuffer{cfg: normalized, re: re}, nil
+}
+
+// isContinuation reports whether line should be merged with the buffer
+// according to the configured pattern, with negate inverting the test.
+func (b *multilineBuffer) isContinuation(line []byte) bool {
+	matched := b.re.Match(line)
+	if b.cfg.Negate {
+		return !matched
+	}
+	return matched
+}
+
+func (b *multilineBuffer) isEmpty() bool {
+	return len(b.lines) == 0
+}
+
+// append adds a line (already a defensive copy, safe to retain) to the
+// buffer, tracking the offsets/line number needed to emit a replayable State.
+func (b *multilineBuffer) append(line []byte, startOffset, nextOffset, lineNumber int64, contentBytes int64, now time.Time) {
+	if b.isEmpty() {
+		b.firstOffset = startOffset
+		b.firstLineNo = lineNumber
+	}
+	b.lines = append(b.lines, line)
+	b.totalBytes += contentBytes
+	b.lastNextOffset = nextOffset
+	b.updated = now
+}
+
+func (b *multilineBuffer) exceedsLimits() bool {
+	return len(b.lines) >= b.cfg.MaxLines || b.totalBytes >= b.cfg.MaxBytes
+}
+
+// idle reports whether the buffer has been waiting longer than flushTimeout
+// for its boundary line, so a stalled source (eg no more log lines written)
+// still flushes the event it already has.
+func (b *multilineBuffer) idle(now time.Time) bool {
+	return !b.isEmpty() && now.Sub(b.updated) >= b.cfg.FlushTimeout
+}
+
+func (b *multilineBuffer) reset() {
+	b.lines = nil
+	b.totalBytes = 0
+}
+
+// productMultilineEvent buffers body according to the multiline.match mode
+// and flushes a merged event once a boundary is reached or a limit hit.
+//
+// match: after  - a continuation line is appended to the event that came
+//
+//	before it; a non-continuation line starts a new event, so the
+//	buffer is flushed (if non-empty) before appending it.
+//
+// match: before - a continuation line indicates the *next* line belongs to
+//
+//	the same event, so it's appended and buffering continues; a
+//	non-continuation line completes the event, so the buffer is
+//	flushed right after appending it.
+func (j *Job) productMultilineEvent(startOffset, nextOffset, lineNumber int64, collectTime time.Time, contentBytes int64, body []byte) {
+	j.multilineMu.Lock()
+	defer j.multilineMu.Unlock()
+
+	buf := j.multiline
+	continuation := buf.isContinuation(body)
+
+	lineCopy := make([]byte, len(body))
+	copy(lineCopy, body)
+
+	switch buf.cfg.Match {
+	case MultilineMatchBefore:
+		buf.append(lineCopy, startOffset, nextOffset, lineNumber, contentBytes, collectTime)
+		if !continuation {
+			j.flushMultiline(collectTime)
+		}
+	default: // MultilineMatchAfter
+		if !buf.isEmpty() && !continuation {
+			j.flushMultiline(collectTime)
+		}
+		buf.append(lineCopy, startOffset, nextOffset, lineNumber, contentBytes, collectTime)
+	}
+
+	if buf.exceedsLimits() {
+		j.flushMultiline(collectTime)
+	}
+}
+
+// flushMultiline emits the buffered lines, if any, as a single event.
+func (j *Job) flushMultiline(collectTime time.Time) {
+	buf := j.multiline
+	if buf.isEmpty() {
+		return
+	}
+	j.emitEvent(buf.firstOffset, buf.lastNextOffset, buf.firstLineNo, collectTime, buf.totalBytes, buf.lines)
+	buf.reset()
+}
+
+// FlushIdleMultiline is driven by the source's idle-flush ticker (one per
+// WatchTask, see runIdleFlush, calling it for every job) so
+// a multi-line event isn't held indefinitely waiting for a boundary line
+// that never arrives (eg the process stopped logging). It takes multilineMu
+// itself since, unlike productMultilineEvent, it runs on the ticker's own
+// goroutine rather than the reader loop's.
+func (j *Job) FlushIdleMultiline(now time.Time) {
+	j.multilineMu.Lock()
+	defer j.multilineMu.Unlock()
+
+	if j.multiline == nil || !j.multiline.idle(now) {
+		return
+	}
+	j.flushMultiline(now)
+}
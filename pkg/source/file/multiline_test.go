@@ -0,0 +1,179 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMultilineBufferIsContinuation(t *testing.T) {
+	buf, err := newMultilineBuffer(&MultilineConfig{Pattern: `^\s`})
+	if err != nil {
+		t.Fatalf("newMultilineBuffer error: %s", err)
+	}
+	if !buf.isContinuation([]byte("  at foo.bar()")) {
+		t.Errorf("indented line should be a continuation")
+	}
+	if buf.isContinuation([]byte("ERROR something broke")) {
+		t.Errorf("unindented line should not be a continuation")
+	}
+}
+
+func TestMultilineBufferIsContinuationNegate(t *testing.T) {
+	buf, err := newMultilineBuffer(&MultilineConfig{Pattern: `^\d{4}-`, Negate: true})
+	if err != nil {
+		t.Fatalf("newMultilineBuffer error: %s", err)
+	}
+	// negate: lines NOT matching the timestamp pattern are continuations.
+	if !buf.isContinuation([]byte("  at foo.bar()")) {
+		t.Errorf("non-timestamp line should be a continuation under negate")
+	}
+	if buf.isContinuation([]byte("2021-07-04 new entry")) {
+		t.Errorf("timestamp line should not be a continuation under negate")
+	}
+}
+
+func TestMultilineBufferExceedsLimits(t *testing.T) {
+	buf, err := newMultilineBuffer(&MultilineConfig{Pattern: `.`, MaxLines: 2, MaxBytes: 1024})
+	if err != nil {
+		t.Fatalf("newMultilineBuffer error: %s", err)
+	}
+	now := time.Unix(0, 0)
+	buf.append([]byte("a"), 0, 2, 1, 2, now)
+	if buf.exceedsLimits() {
+		t.Fatalf("1 line should not exceed maxLines=2")
+	}
+	buf.append([]byte("b"), 2, 4, 2, 2, now)
+	if !buf.exceedsLimits() {
+		t.Fatalf("2 lines should exceed maxLines=2")
+	}
+}
+
+func TestMultilineBufferIdle(t *testing.T) {
+	buf, err := newMultilineBuffer(&MultilineConfig{Pattern: `.`, FlushTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("newMultilineBuffer error: %s", err)
+	}
+	start := time.Unix(100, 0)
+	if buf.idle(start) {
+		t.Errorf("empty buffer should never be idle")
+	}
+	buf.append([]byte("a"), 0, 2, 1, 2, start)
+	if buf.idle(start.Add(500 * time.Millisecond)) {
+		t.Errorf("buffer updated 500ms ago should not be idle yet (timeout=1s)")
+	}
+	if !buf.idle(start.Add(2 * time.Second)) {
+		t.Errorf("buffer updated 2s ago should be idle (timeout=1s)")
+	}
+}
+
+// fakeEvent captures what was produced so tests can assert on it.
+type fakeEvent struct {
+	header map[string]interface{}
+	body   []byte
+}
+
+func (e *fakeEvent) Fill(header map[string]interface{}, body []byte) {
+	e.header = header
+	e.body = body
+}
+
+type fakeEventPool struct{}
+
+func (fakeEventPool) Get() Event {
+	return &fakeEvent{}
+}
+
+// newTestJob builds a Job with a working multiline buffer and a productFunc
+// that records every emitted event's body, so productMultilineEvent's
+// buffering decisions can be asserted on directly.
+func newTestJob(t *testing.T, cfg *MultilineConfig) (*Job, *[][]byte) {
+	t.Helper()
+	var produced [][]byte
+	task := &WatchTask{
+		pipelineName: "p",
+		sourceName:   "s",
+		config:       &Config{MultiLine: cfg},
+		eventPool:    fakeEventPool{},
+		productFunc: func(e Event) {
+			produced = append(produced, e.(*fakeEvent).body)
+		},
+	}
+	job := newJobWithUid(task, "test.log", "test-uid")
+	return job, &produced
+}
+
+func TestProductMultilineEventMatchAfter(t *testing.T) {
+	job, produced := newTestJob(t, &MultilineConfig{Pattern: `^\s`, Match: MultilineMatchAfter})
+
+	emit := func(line string) {
+		job.ProductEvent(int64(len(line))-1, time.Now(), []byte(line))
+	}
+	emit("ERROR first")
+	emit("  at foo()")
+	emit("  at bar()")
+	emit("ERROR second")
+
+	if len(*produced) != 1 {
+		t.Fatalf("got %d events before the trailing event is flushed, want 1: %+v", len(*produced), *produced)
+	}
+	want := "ERROR first\n  at foo()\n  at bar()"
+	if string((*produced)[0]) != want {
+		t.Errorf("event 0 = %q, want %q", (*produced)[0], want)
+	}
+}
+
+func TestProductMultilineEventMatchBefore(t *testing.T) {
+	job, produced := newTestJob(t, &MultilineConfig{Pattern: `\\$`, Match: MultilineMatchBefore})
+
+	emit := func(line string) {
+		job.ProductEvent(int64(len(line))-1, time.Now(), []byte(line))
+	}
+	emit(`continued\`)
+	emit("end of statement")
+
+	if len(*produced) != 1 {
+		t.Fatalf("got %d events, want 1: %+v", len(*produced), *produced)
+	}
+	want := "continued\\\nend of statement"
+	if string((*produced)[0]) != want {
+		t.Errorf("event 0 = %q, want %q", (*produced)[0], want)
+	}
+}
+
+func TestFlushIdleMultiline(t *testing.T) {
+	job, produced := newTestJob(t, &MultilineConfig{Pattern: `^\s`, Match: MultilineMatchAfter, FlushTimeout: time.Second})
+
+	job.ProductEvent(10, time.Unix(100, 0), []byte("ERROR unterminated"))
+	if len(*produced) != 0 {
+		t.Fatalf("buffer should not have flushed yet: %+v", *produced)
+	}
+
+	job.FlushIdleMultiline(time.Unix(100, 0).Add(500 * time.Millisecond))
+	if len(*produced) != 0 {
+		t.Fatalf("buffer should not be idle yet: %+v", *produced)
+	}
+
+	job.FlushIdleMultiline(time.Unix(100, 0).Add(2 * time.Second))
+	if len(*produced) != 1 {
+		t.Fatalf("idle buffer should have flushed, got %d events", len(*produced))
+	}
+	if string((*produced)[0]) != "ERROR unterminated" {
+		t.Errorf("event 0 = %q, want %q", (*produced)[0], "ERROR unterminated")
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+
+	"loggie.io/loggie/pkg/core/log"
+)
+
+// runLoop consumes Jobs sent on activeChan and reads whatever's newly
+// available from each. It reads through Job.Reader(), which is the
+// decompressed stream for a compressed archive and the raw file
+// otherwise, so archives actually get decoded instead of being tailed as
+// raw gzip/zstd/xz bytes.
+func (t *WatchTask) runLoop() {
+	for job := range t.activeChan {
+		t.readAvailable(job)
+	}
+}
+
+// runIdleFlush ticks every interval and flushes any job whose buffered
+// multi-line event has gone idle, so a span whose boundary line never
+// arrives (eg the process crashed mid-stack-trace) doesn't sit buffered
+// forever. It exits once stop is closed.
+//
+// The job list is snapshotted under t.mu and then iterated with the lock
+// released: FlushIdleMultiline can end up emitting an event, which reads
+// t.staticFields under t.mu.RLock() itself, and sync.RWMutex's read lock
+// isn't safe to reacquire recursively on the same goroutine while a writer
+// (AddPaths/RemovePaths) may be queued in between.
+func (t *WatchTask) runIdleFlush(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			t.mu.RLock()
+			jobs := make([]*Job, 0, len(t.jobs))
+			for _, job := range t.jobs {
+				jobs = append(jobs, job)
+			}
+			t.mu.RUnlock()
+
+			for _, job := range jobs {
+				job.FlushIdleMultiline(now)
+			}
+		}
+	}
+}
+
+// readAvailable drains whatever Job.Reader() currently has newly available,
+// handing each line to Job.ProductEvent. It reads through j.lineReader, a
+// bufio.Reader kept on the Job across calls (not rebuilt here each time): an
+// actively-written file often has a write land mid-line between two reads,
+// and a fresh bufio.Reader would read that trailing partial line off the
+// stream and then discard it when ReadBytes hit EOF before the delimiter,
+// permanently losing those bytes and desyncing j.nextOffset from the real
+// file position. j.pendingLine carries that partial line forward until the
+// rest of it arrives.
+//
+// On EOF of a compressed archive it calls MarkFullyRead so deleteAfterRead
+// (if configured) can safely remove it: a live tailed file just waits for
+// more data, but an archive won't grow any further once fully consumed.
+func (t *WatchTask) readAvailable(j *Job) {
+	if j.lineReader == nil {
+		j.lineReader = bufio.NewReader(j.Reader())
+	}
+
+	for {
+		chunk, err := j.lineReader.ReadBytes('\n')
+		j.pendingLine = append(j.pendingLine, chunk...)
+
+		if err != nil {
+			if err == io.EOF {
+				// j.pendingLine (if any) is an unterminated partial line;
+				// leave it buffered on the Job so the next read cycle picks
+				// up where this one left off instead of losing it.
+				j.eofCount++
+				if j.IsCompressed() {
+					j.MarkFullyRead()
+				}
+				return
+			}
+			log.Error("read job(filename: %s) error: %s", j.filename, err)
+			return
+		}
+
+		line := bytes.TrimSuffix(j.pendingLine, []byte("\n"))
+		endOffset := j.nextOffset + int64(len(line))
+		j.ProductEvent(endOffset, time.Now(), line)
+		j.pendingLine = nil
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestFile opens filename for reading, wiring the resulting *os.File up
+// as job.file so readAvailable can be exercised directly without going
+// through AddPaths/Active's identity plumbing.
+func openTestFile(t *testing.T, job *Job, filename string) {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("open(%s) error: %s", filename, err)
+	}
+	t.Cleanup(func() { file.Close() })
+	job.file = file
+}
+
+// TestReadAvailableCarriesOverPartialLine covers the bug the persistent
+// lineReader/pendingLine fixes: a write landing mid-line between two
+// readAvailable calls must not lose the partial line it leaves behind.
+func TestReadAvailableCarriesOverPartialLine(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(filename, []byte("AAAA\nBBBB"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+
+	job, produced := newTestJob(t, nil)
+	openTestFile(t, job, filename)
+
+	job.task.readAvailable(job)
+	if len(*produced) != 1 || string((*produced)[0]) != "AAAA" {
+		t.Fatalf("got %+v, want a single event \"AAAA\"", stringsOf(*produced))
+	}
+	if string(job.pendingLine) != "BBBB" {
+		t.Fatalf("pendingLine = %q, want %q (the unterminated trailing line)", job.pendingLine, "BBBB")
+	}
+
+	// simulate a second write landing after "BBBB", completing the line.
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile error: %s", err)
+	}
+	if _, err := file.WriteString("CC\n"); err != nil {
+		t.Fatalf("WriteString error: %s", err)
+	}
+	file.Close()
+
+	job.task.readAvailable(job)
+	if len(*produced) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(*produced), stringsOf(*produced))
+	}
+	if string((*produced)[1]) != "BBBBCC" {
+		t.Errorf("event 1 = %q, want %q (pending \"BBBB\" + new \"CC\")", (*produced)[1], "BBBBCC")
+	}
+	if job.pendingLine != nil {
+		t.Errorf("pendingLine = %q, want nil after the line completed", job.pendingLine)
+	}
+}
+
+// TestReadAvailableMultipleLines covers the ordinary case: several complete
+// lines available in one read all get produced, in order, with nothing left
+// pending.
+func TestReadAvailableMultipleLines(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(filename, []byte("one\ntwo\nthree\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+
+	job, produced := newTestJob(t, nil)
+	openTestFile(t, job, filename)
+
+	job.task.readAvailable(job)
+
+	want := []string{"one", "two", "three"}
+	if len(*produced) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(*produced), len(want), stringsOf(*produced))
+	}
+	for i, w := range want {
+		if string((*produced)[i]) != w {
+			t.Errorf("event %d = %q, want %q", i, (*produced)[i], w)
+		}
+	}
+	if job.pendingLine != nil {
+		t.Errorf("pendingLine = %q, want nil: every line was newline-terminated", job.pendingLine)
+	}
+}
+
+func stringsOf(bs [][]byte) []string {
+	out := make([]string, len(bs))
+	for i, b := range bs {
+		out[i] = string(b)
+	}
+	return out
+}
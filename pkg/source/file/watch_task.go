@@ -0,0 +1,273 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"loggie.io/loggie/pkg/core/log"
+	coredisc "loggie.io/loggie/pkg/discovery"
+	filediscovery "loggie.io/loggie/pkg/source/file/discovery"
+
+	"github.com/hashicorp/consul/api"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Epoch scopes a WatchTask's Jobs to one generation of the pipeline/source
+// it belongs to, so Jobs left over from a stopped task are never confused
+// with a freshly (re)started one.
+type Epoch struct {
+	PipelineName string
+	Generation   int64
+}
+
+// Event is a single collected line or multi-line span, carrying its system
+// State header alongside the raw body.
+type Event interface {
+	Fill(header map[string]interface{}, body []byte)
+}
+
+// EventPool hands out reusable Events so the hot ProductEvent path doesn't
+// allocate one per line.
+type EventPool interface {
+	Get() Event
+}
+
+// ProductFunc hands a produced Event off to the rest of the pipeline.
+type ProductFunc func(Event)
+
+// Config is a file source's static pipeline-file configuration, plus the
+// discovery providers (if any) it should layer dynamically discovered
+// paths on top of.
+type Config struct {
+	FirstNBytesForIdentifier int `yaml:"firstNBytesForIdentifier,omitempty"`
+
+	MultiLine   *MultilineConfig   `yaml:"multiline,omitempty"`
+	Compression *CompressionConfig `yaml:"compression,omitempty"`
+
+	Kubernetes *filediscovery.KubernetesConfig `yaml:"kubernetes,omitempty"`
+	Consul     *filediscovery.ConsulConfig     `yaml:"consul,omitempty"`
+}
+
+const SystemStateKey = "state"
+
+// State is the replayable checkpoint for one produced event: where it
+// starts/ends in the file (or, for a compressed archive, in the virtual
+// decompressed stream) and which line it starts at.
+type State struct {
+	Epoch        Epoch
+	PipelineName string
+	SourceName   string
+	Offset       int64
+	NextOffset   int64
+	LineNumber   int64
+	Filename     string
+	CollectTime  time.Time
+	ContentBytes int64
+	JobUid       string
+	JobIndex     uint32
+	watchUid     string
+	EventUid     string
+}
+
+// WatchTask owns one pipeline/source pair's collection state: the live Jobs
+// tailing its configured paths, plus - once AddPaths/RemovePaths is driven
+// by a discovery.Manager - whatever a Kubernetes/Consul provider has
+// synthesized at runtime. Jobs for paths that a reload doesn't touch are
+// left running untouched, so their offsets are never lost.
+type WatchTask struct {
+	pipelineName string
+	sourceName   string
+	epoch        Epoch
+	config       *Config
+
+	eventPool   EventPool
+	productFunc ProductFunc
+	activeChan  chan *Job
+	stopChan    chan struct{}
+
+	mu   sync.RWMutex
+	jobs map[string]*Job // by filename
+
+	// jobsByPath indexes jobs by the AddPaths pattern that discovered them,
+	// so RemovePaths can retire exactly the jobs a path contributed without
+	// re-globbing it - a removed Kubernetes pod's log directory is typically
+	// already gone by the time RemovePaths runs, so a re-glob would find
+	// nothing and leak the job.
+	jobsByPath map[string]map[string]*Job // path -> filename -> job
+
+	// staticFields holds metadata injected by source discovery (pod labels,
+	// Consul tags, ...), keyed by the path pattern that contributed it, and
+	// merged into every event collected from a file under that path.
+	staticFields map[string]map[string]interface{}
+
+	discovery *filediscovery.Manager
+}
+
+// NewWatchTask builds the task and, if config carries a Kubernetes and/or
+// Consul discovery config, starts a discovery.Manager in the background
+// that reconciles discovered targets into AddPaths/RemovePaths calls.
+func NewWatchTask(pipelineName, sourceName string, epoch Epoch, config *Config, eventPool EventPool, productFunc ProductFunc) (*WatchTask, error) {
+	task := &WatchTask{
+		pipelineName: pipelineName,
+		sourceName:   sourceName,
+		epoch:        epoch,
+		config:       config,
+		eventPool:    eventPool,
+		productFunc:  productFunc,
+		activeChan:   make(chan *Job),
+		stopChan:     make(chan struct{}),
+		jobs:         make(map[string]*Job),
+		jobsByPath:   make(map[string]map[string]*Job),
+		staticFields: make(map[string]map[string]interface{}),
+	}
+
+	go task.runLoop()
+
+	if config.MultiLine != nil {
+		flushTimeout := config.MultiLine.FlushTimeout
+		if flushTimeout <= 0 {
+			flushTimeout = defaultMultilineFlushTimeout
+		}
+		go task.runIdleFlush(flushTimeout, task.stopChan)
+	}
+
+	providers, err := discoveryProviders(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(providers) > 0 {
+		task.discovery = filediscovery.NewManager(task, providers...)
+		go task.discovery.Run()
+	}
+
+	return task, nil
+}
+
+func discoveryProviders(config *Config) ([]coredisc.Provider, error) {
+	var providers []coredisc.Provider
+
+	if config.Kubernetes != nil {
+		client, err := newKubernetesClient()
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes discovery client error: %w", err)
+		}
+		providers = append(providers, filediscovery.NewKubernetesProvider(config.Kubernetes, client))
+	}
+
+	if config.Consul != nil {
+		client, err := api.NewClient(&api.Config{Address: config.Consul.Address})
+		if err != nil {
+			return nil, fmt.Errorf("consul discovery client error: %w", err)
+		}
+		providers = append(providers, filediscovery.NewConsulProvider(config.Consul, client))
+	}
+
+	return providers, nil
+}
+
+func newKubernetesClient() (kubernetes.Interface, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("load in-cluster kubeconfig error: %w", err)
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// Stop stops the discovery manager, if one was started, and the idle-flush
+// ticker.
+func (t *WatchTask) Stop() {
+	close(t.stopChan)
+	if t.discovery != nil {
+		t.discovery.Stop()
+	}
+}
+
+// AddPaths globs paths and opens a Job for every matched file not already
+// being watched; files already being collected under a path keep their
+// existing Job (and offset) instead of being restarted. staticFields is
+// recorded per path and merged into events collected from it, and reload
+// is guarded by mu so no event is ever produced against a stale snapshot.
+func (t *WatchTask) AddPaths(paths []string, staticFields map[string]interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, path := range paths {
+		t.staticFields[path] = staticFields
+		if t.jobsByPath[path] == nil {
+			t.jobsByPath[path] = make(map[string]*Job)
+		}
+
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return fmt.Errorf("glob(path: %s) error: %w", path, err)
+		}
+		for _, filename := range matches {
+			if _, ok := t.jobs[filename]; ok {
+				continue
+			}
+			fileInfo, err := os.Stat(filename)
+			if err != nil {
+				log.Error("AddPaths stat(filename: %s) error: %s", filename, err)
+				continue
+			}
+			job, err := NewJob(t, filename, fileInfo)
+			if err != nil {
+				log.Error("AddPaths NewJob(filename: %s) error: %s", filename, err)
+				continue
+			}
+			job.path = path
+			if err := job.Active(); err != nil {
+				log.Error("AddPaths Active(filename: %s) error: %s", filename, err)
+				continue
+			}
+			t.jobs[filename] = job
+			t.jobsByPath[path][filename] = job
+		}
+	}
+	return nil
+}
+
+// RemovePaths stops and releases the Jobs discovered under one of paths,
+// keyed off jobsByPath (as recorded by AddPaths) rather than re-globbing:
+// by the time a Kubernetes pod's Delete event fires, its log directory has
+// typically already been removed, so a re-glob would match nothing and
+// leak the job's file handle and goroutine-visible state forever. Jobs
+// under any path NOT in this list are left completely untouched, so their
+// offsets survive the reload.
+func (t *WatchTask) RemovePaths(paths []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, path := range paths {
+		delete(t.staticFields, path)
+
+		for filename, job := range t.jobsByPath[path] {
+			job.Stop()
+			job.Delete()
+			job.Release()
+			delete(t.jobs, filename)
+		}
+		delete(t.jobsByPath, path)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2021 Loggie Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestWatchTask builds a WatchTask with its path bookkeeping initialized,
+// without starting NewWatchTask's background goroutines: AddPaths/RemovePaths
+// are exercised directly.
+func newTestWatchTask(t *testing.T) *WatchTask {
+	t.Helper()
+	return &WatchTask{
+		pipelineName: "p",
+		sourceName:   "s",
+		config:       &Config{},
+		eventPool:    fakeEventPool{},
+		productFunc:  func(Event) {},
+		jobs:         make(map[string]*Job),
+		jobsByPath:   make(map[string]map[string]*Job),
+		staticFields: make(map[string]map[string]interface{}),
+	}
+}
+
+func TestAddPathsOpensJobsAndRecordsStaticFields(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "pod.log")
+	if err := os.WriteFile(filename, []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+	path := filepath.Join(dir, "*.log")
+
+	task := newTestWatchTask(t)
+	fields := map[string]interface{}{"pod": "a"}
+	if err := task.AddPaths([]string{path}, fields); err != nil {
+		t.Fatalf("AddPaths error: %s", err)
+	}
+
+	job, ok := task.jobs[filename]
+	if !ok {
+		t.Fatalf("AddPaths did not open a job for %s", filename)
+	}
+	if job.path != path {
+		t.Errorf("job.path = %q, want %q", job.path, path)
+	}
+	if _, ok := task.jobsByPath[path][filename]; !ok {
+		t.Errorf("jobsByPath[%q] does not record %s", path, filename)
+	}
+	if got := task.staticFields[path]["pod"]; got != "a" {
+		t.Errorf("staticFields[%q][\"pod\"] = %v, want %q", path, got, "a")
+	}
+}
+
+func TestAddPathsLeavesAlreadyTrackedJobUntouched(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "pod.log")
+	if err := os.WriteFile(filename, []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+	path := filepath.Join(dir, "*.log")
+
+	task := newTestWatchTask(t)
+	if err := task.AddPaths([]string{path}, nil); err != nil {
+		t.Fatalf("AddPaths error: %s", err)
+	}
+	first := task.jobs[filename]
+
+	// re-add the same path, as a reload/reconcile would: the existing job
+	// (and its offset) must not be replaced.
+	if err := task.AddPaths([]string{path}, nil); err != nil {
+		t.Fatalf("second AddPaths error: %s", err)
+	}
+	second := task.jobs[filename]
+
+	if first != second {
+		t.Errorf("AddPaths replaced the already-tracked job for %s instead of leaving it alone", filename)
+	}
+}
+
+func TestRemovePathsRetiresJobsEvenWhenGlobNoLongerMatches(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "pod.log")
+	if err := os.WriteFile(filename, []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+	path := filepath.Join(dir, "*.log")
+
+	task := newTestWatchTask(t)
+	if err := task.AddPaths([]string{path}, map[string]interface{}{"pod": "a"}); err != nil {
+		t.Fatalf("AddPaths error: %s", err)
+	}
+	if _, ok := task.jobs[filename]; !ok {
+		t.Fatalf("setup: expected job for %s", filename)
+	}
+
+	// simulate the container/pod already being gone: the log directory
+	// (and hence the glob match) no longer exists by the time RemovePaths
+	// is called, as is typical for a Kubernetes Delete event.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("RemoveAll error: %s", err)
+	}
+
+	if err := task.RemovePaths([]string{path}); err != nil {
+		t.Fatalf("RemovePaths error: %s", err)
+	}
+
+	if _, ok := task.jobs[filename]; ok {
+		t.Errorf("RemovePaths left %s in jobs after its path was removed", filename)
+	}
+	if _, ok := task.jobsByPath[path]; ok {
+		t.Errorf("RemovePaths left jobsByPath[%q] populated", path)
+	}
+	if _, ok := task.staticFields[path]; ok {
+		t.Errorf("RemovePaths left staticFields[%q] populated", path)
+	}
+}
+
+func TestRemovePathsLeavesOtherPathsUntouched(t *testing.T) {
+	dir := t.TempDir()
+	keepFilename := filepath.Join(dir, "keep.log")
+	dropFilename := filepath.Join(dir, "drop.log")
+	if err := os.WriteFile(keepFilename, []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+	if err := os.WriteFile(dropFilename, []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+	keepPath := keepFilename
+	dropPath := dropFilename
+
+	task := newTestWatchTask(t)
+	if err := task.AddPaths([]string{keepPath, dropPath}, nil); err != nil {
+		t.Fatalf("AddPaths error: %s", err)
+	}
+
+	if err := task.RemovePaths([]string{dropPath}); err != nil {
+		t.Fatalf("RemovePaths error: %s", err)
+	}
+
+	if _, ok := task.jobs[keepFilename]; !ok {
+		t.Errorf("RemovePaths(%q) also removed the job for untouched path %q", dropPath, keepPath)
+	}
+	if _, ok := task.jobs[dropFilename]; ok {
+		t.Errorf("RemovePaths(%q) did not remove its own job", dropPath)
+	}
+}